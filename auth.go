@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// authLoginRequest is the JSON body accepted by /auth/login.
+type authLoginRequest struct {
+	User         string `json:"user"`
+	Password     string `json:"password"`
+	StayLoggedIn bool   `json:"stay_logged_in"`
+}
+
+// authenticateUser validates user/password against the configured SiriDB
+// connection(s). With multi_user disabled both must match the [Database]
+// credentials; with multi_user enabled the connection is resolved (and, on
+// first use, opened) through connForBasicAuthUser and its password must
+// match.
+func authenticateUser(user, password string) (Conn, error) {
+	if !base.multiUser {
+		for _, conn := range base.connections {
+			if conn.user == user && conn.password == password {
+				return conn, nil
+			}
+		}
+		return Conn{}, fmt.Errorf("invalid SiriDB user or password")
+	}
+
+	conn, err := connForBasicAuthUser(user, password)
+	if err != nil {
+		return Conn{}, err
+	}
+	if conn.password != password {
+		return Conn{}, fmt.Errorf("invalid SiriDB user or password")
+	}
+	return conn, nil
+}
+
+// handlerAuthLogin authenticates a user/password pair against SiriDB and, on
+// success, starts a session cookie the way handlerOIDCCallback does for
+// OIDC logins. stay_logged_in selects remember_me_max_age over
+// cookie_max_age for the session's lifetime.
+func handlerAuthLogin(w http.ResponseWriter, r *http.Request) {
+	var req authLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := authenticateUser(req.User, req.Password)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := createSession(w, conn.user, req.StayLoggedIn); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlerAuthLogout invalidates the current session cookie, mirroring
+// handlerOIDCLogout for password logins.
+func handlerAuthLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		base.tokens.delete(cookie.Value)
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "", MaxAge: -1, Path: "/"})
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	auth "github.com/abbot/go-http-auth"
+	siridb "github.com/transceptor-technology/go-siridb-connector"
+)
+
+type contextKey int
+
+const connContextKey contextKey = 0
+
+// basicAuthConns caches one SiriDB connection per multi_user Basic Auth
+// username, so a scraper (Grafana/Prometheus) hitting the API on every
+// scrape reuses a connection instead of opening a new one per request.
+// locks holds one mutex per username, taken for the whole
+// check-cache/connect/store-in-cache sequence in connForBasicAuthUser so
+// concurrent first requests for the same new user can't each miss the cache
+// and open their own, leaked connection.
+var basicAuthConns = struct {
+	mux   sync.Mutex
+	conns map[string]Conn
+	locks map[string]*sync.Mutex
+}{conns: make(map[string]Conn), locks: make(map[string]*sync.Mutex)}
+
+// basicAuthUserLock returns the mutex serializing connection creation for
+// user, creating it on first use.
+func basicAuthUserLock(user string) *sync.Mutex {
+	basicAuthConns.mux.Lock()
+	defer basicAuthConns.mux.Unlock()
+
+	l, ok := basicAuthConns.locks[user]
+	if !ok {
+		l = &sync.Mutex{}
+		basicAuthConns.locks[user] = l
+	}
+	return l
+}
+
+// newBasicAuthenticator builds an htpasswd-backed authenticator for the
+// [Basic] htpasswd_file config key.
+func newBasicAuthenticator(htpasswdFile string) *auth.BasicAuth {
+	secrets := auth.HtpasswdFileProvider(htpasswdFile)
+	return auth.NewBasicAuthenticator("siridb-http", secrets)
+}
+
+// wrapOptionalBasicAuth wraps next with HTTP Basic Auth when [Basic]
+// htpasswd_file is configured, otherwise it falls back to session cookie
+// authentication.
+func wrapOptionalBasicAuth(next http.HandlerFunc) http.HandlerFunc {
+	if base.basicAuth == nil {
+		return wrapSessionAuth(next)
+	}
+	return basicAuthWrap(base.basicAuth, next)
+}
+
+// basicAuthWrap authenticates a request against the configured htpasswd
+// file and, on success, resolves the credentials to a SiriDB connection
+// before calling next. This lets automation (curl/Grafana/Prometheus) use
+// the API without the /auth/login cookie dance.
+func basicAuthWrap(a *auth.BasicAuth, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || a.CheckAuth(r) == "" {
+			a.RequireAuth(w, r)
+			return
+		}
+
+		conn, err := connForBasicAuthUser(user, password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		next(w, attachConn(r, conn))
+	}
+}
+
+// connForBasicAuthUser resolves a validated Basic Auth user/password pair to
+// the SiriDB connection a request should execute under. With multi_user
+// disabled, only the single connection configured in [Database] is allowed.
+// With multi_user enabled, a connection is created on first use for that
+// username, cached in basicAuthConns and reused by later requests.
+func connForBasicAuthUser(user, password string) (Conn, error) {
+	if !base.multiUser {
+		for _, conn := range base.connections {
+			if conn.user == user {
+				return conn, nil
+			}
+		}
+		return Conn{}, fmt.Errorf("unknown SiriDB user: %s", user)
+	}
+
+	lock := basicAuthUserLock(user)
+	lock.Lock()
+	defer lock.Unlock()
+
+	basicAuthConns.mux.Lock()
+	conn, ok := basicAuthConns.conns[user]
+	basicAuthConns.mux.Unlock()
+	if ok {
+		return conn, nil
+	}
+
+	conn = Conn{
+		user:     user,
+		password: password,
+		client: siridb.NewClient(
+			user,
+			password,
+			base.dbname,
+			ServersToInterface(base.servers),
+			base.logCh,
+		),
+	}
+	conn.client.Connect()
+
+	if err := waitForConnected(conn.client, time.Duration(base.insertTimeout)*time.Second); err != nil {
+		conn.client.Close()
+		return Conn{}, err
+	}
+
+	basicAuthConns.mux.Lock()
+	basicAuthConns.conns[user] = conn
+	basicAuthConns.mux.Unlock()
+
+	return conn, nil
+}
+
+// waitForConnected blocks, the same way connect() does for the startup
+// connection, until client is connected or timeout elapses.
+func waitForConnected(client *siridb.Client, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for !client.IsConnected() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for SiriDB connection")
+		}
+		time.Sleep(retryConnectTime * time.Second)
+	}
+	return nil
+}
+
+// closeBasicAuthConns closes every cached per-user connection opened for
+// multi_user Basic Auth. Called from quit() on shutdown.
+func closeBasicAuthConns() {
+	basicAuthConns.mux.Lock()
+	defer basicAuthConns.mux.Unlock()
+	for _, conn := range basicAuthConns.conns {
+		if conn.client != nil {
+			conn.client.Close()
+		}
+	}
+}
+
+// attachConn resolves conn onto r: it updates the request-id middleware's
+// connHolder (if present, so the access log can record the user) and
+// returns a derived request carrying conn on its context for downstream
+// handlers.
+func attachConn(r *http.Request, conn Conn) *http.Request {
+	if holder, ok := r.Context().Value(connHolderContextKey{}).(*connHolder); ok {
+		holder.conn = conn
+		holder.ok = true
+	}
+	return r.WithContext(context.WithValue(r.Context(), connContextKey, conn))
+}
+
+// connFromRequest returns the SiriDB connection an auth wrapper resolved
+// for this request, if any.
+func connFromRequest(r *http.Request) (Conn, bool) {
+	conn, ok := r.Context().Value(connContextKey).(Conn)
+	return conn, ok
+}
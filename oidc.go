@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	oidc "github.com/coreos/go-oidc"
+	"golang.org/x/oauth2"
+	ini "gopkg.in/ini.v1"
+)
+
+// oidcStateTTL bounds how long a CSRF state handed out by handlerOIDCLogin
+// stays valid, so an abandoned login (closed tab, failed redirect) doesn't
+// linger in oidcStates forever.
+const oidcStateTTL = 10 * time.Minute
+
+// oidcStates tracks CSRF state values handed out by handlerOIDCLogin, each
+// valid for a single callback until it expires.
+var oidcStates = struct {
+	mux sync.Mutex
+	m   map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+func newOIDCState() (string, error) {
+	state, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	oidcStates.mux.Lock()
+	oidcStates.m[state] = time.Now().Add(oidcStateTTL)
+	oidcStates.mux.Unlock()
+
+	return state, nil
+}
+
+func takeOIDCState(state string) bool {
+	oidcStates.mux.Lock()
+	defer oidcStates.mux.Unlock()
+
+	expires, ok := oidcStates.m[state]
+	if !ok {
+		return false
+	}
+	delete(oidcStates.m, state)
+	return time.Now().Before(expires)
+}
+
+// pruneOIDCStates removes expired CSRF states, the same way pruneTokens
+// does for session tokens. It is meant to run for the lifetime of the
+// process, started with `go` from setupOIDC.
+func pruneOIDCStates() {
+	for {
+		time.Sleep(pruneInterval)
+
+		oidcStates.mux.Lock()
+		now := time.Now()
+		for state, expires := range oidcStates.m {
+			if now.After(expires) {
+				delete(oidcStates.m, state)
+			}
+		}
+		oidcStates.mux.Unlock()
+	}
+}
+
+// setupOIDC reads the [OIDC] section and wires up base.oidcConfig and
+// base.oidcVerifier against the configured issuer.
+func setupOIDC(section *ini.Section) error {
+	issuerURL := readString(section, "issuer_url")
+
+	provider, err := oidc.NewProvider(context.Background(), issuerURL)
+	if err != nil {
+		return fmt.Errorf("failed to discover OIDC issuer %s: %s", issuerURL, err)
+	}
+
+	clientID := readString(section, "client_id")
+
+	base.oidcConfig = oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: readString(section, "client_secret"),
+		RedirectURL:  readString(section, "redirect_url"),
+		Endpoint:     provider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+	}
+	base.oidcVerifier = provider.Verifier(&oidc.Config{ClientID: clientID})
+	base.oidcUsernameClaim = readString(section, "username_claim")
+
+	var claims struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	provider.Claims(&claims)
+	base.oidcEndSessionURL = claims.EndSessionEndpoint
+
+	base.oidcUserMap = make(map[string]string)
+	for _, pair := range strings.Split(readString(section, "siridb_user_map"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid siridb_user_map entry: %s", pair)
+		}
+		base.oidcUserMap[kv[0]] = kv[1]
+	}
+
+	go pruneOIDCStates()
+
+	return nil
+}
+
+// handlerOIDCLogin redirects the browser to the issuer's authorization
+// endpoint to start the standard authorization-code flow.
+func handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	state, err := newOIDCState()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, base.oidcConfig.AuthCodeURL(state), http.StatusFound)
+}
+
+// handlerOIDCCallback completes the authorization-code flow: it exchanges
+// the code for tokens, verifies the ID token, maps the configured claim to
+// a SiriDB user via siridb_user_map and, on success, creates the same kind
+// of server-side session handlerAuthLogin creates for password logins.
+func handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if !takeOIDCState(r.URL.Query().Get("state")) {
+		http.Error(w, "invalid or expired state", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	token, err := base.oidcConfig.Exchange(ctx, r.URL.Query().Get("code"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("token exchange failed: %s", err), http.StatusUnauthorized)
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		http.Error(w, "no id_token in token response", http.StatusUnauthorized)
+		return
+	}
+
+	idToken, err := base.oidcVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("id_token verification failed: %s", err), http.StatusUnauthorized)
+		return
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	extUser, ok := claims[base.oidcUsernameClaim].(string)
+	if !ok {
+		http.Error(w, fmt.Sprintf("missing claim %s in id_token", base.oidcUsernameClaim), http.StatusUnauthorized)
+		return
+	}
+
+	siridbUser, ok := base.oidcUserMap[extUser]
+	if !ok {
+		http.Error(w, fmt.Sprintf("%s is not mapped to a SiriDB user", extUser), http.StatusForbidden)
+		return
+	}
+
+	if _, err := createSession(w, siridbUser, false); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// handlerOIDCLogout clears the local session and, when the issuer exposes
+// one, redirects to its end-session endpoint.
+func handlerOIDCLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		base.tokens.delete(cookie.Value)
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "", MaxAge: -1, Path: "/"})
+
+	if base.oidcEndSessionURL == "" {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	http.Redirect(w, r, base.oidcEndSessionURL, http.StatusFound)
+}
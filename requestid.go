@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+type contextKeyRequestID struct{}
+
+// connHolderContextKey carries a *connHolder on the request context that
+// requestIDMiddleware installs before calling next. Auth wrappers further
+// down the chain (wrapAuth, basicAuthWrap, wrapSessionAuth) write the
+// resolved Conn into it via attachConn, since they each derive their own
+// request with r.WithContext and never hand it back to the middleware
+// directly — the shared pointer is what lets the access log still learn
+// which user a request authenticated as.
+type connHolderContextKey struct{}
+
+type connHolder struct {
+	conn Conn
+	ok   bool
+}
+
+// accessLogRecord is one structured entry logged for every HTTP request and
+// socket.io event, so a slow /query can be correlated with the matching
+// SiriDB server log by request id.
+type accessLogRecord struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	SocketID   string    `json:"socket_id,omitempty"`
+	User       string    `json:"user,omitempty"`
+	Path       string    `json:"path"`
+	QueryHash  string    `json:"query_hash,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	Status     int       `json:"status"`
+}
+
+// newRequestID returns a random UUIDv4, used when a caller did not already
+// supply an X-Request-ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKeyRequestID{}).(string)
+	return id
+}
+
+// hashQuery returns a short, non-reversible identifier for a SiriDB query so
+// it can appear in logs without leaking the query text itself.
+func hashQuery(q string) string {
+	if q == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(q))
+	return hex.EncodeToString(sum[:8])
+}
+
+// statusRecorder captures the status code written by the wrapped handler so
+// it can be included in the access log line.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// handle registers fn for pattern wrapped with the request id middleware.
+// Every route in main() should be registered through handle rather than
+// calling http.HandleFunc directly.
+func handle(pattern string, fn http.HandlerFunc) {
+	http.HandleFunc(pattern, requestIDMiddleware(fn))
+}
+
+// requestIDMiddleware stamps the request with a correlation id (reusing
+// X-Request-ID when the caller already set one), exposes it as a response
+// header and on the request context, and emits one accessLogRecord per
+// request to base.accessLogCh once the handler returns.
+func requestIDMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		holder := &connHolder{}
+		ctx := context.WithValue(r.Context(), contextKeyRequestID{}, id)
+		ctx = context.WithValue(ctx, connHolderContextKey{}, holder)
+		r = r.WithContext(ctx)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		next(rec, r)
+
+		user := ""
+		if holder.ok {
+			user = holder.conn.user
+		}
+
+		base.accessLogCh <- accessLogRecord{
+			Time:       start,
+			RequestID:  id,
+			RemoteAddr: r.RemoteAddr,
+			User:       user,
+			Path:       r.URL.Path,
+			QueryHash:  hashQuery(r.URL.Query().Get("q")),
+			DurationMs: time.Since(start).Milliseconds(),
+			Status:     rec.status,
+		}
+	}
+}
+
+// logSocketEvent runs fn, a socket.io event handler, and emits an
+// accessLogRecord for it the same way requestIDMiddleware does for HTTP
+// requests. The request id is also injected into the ack payload, when it
+// is a JSON object, so it can be correlated client-side.
+func logSocketEvent(so *socketio.Socket, event string, fn func() (int, string)) (int, string) {
+	id := newRequestID()
+	start := time.Now()
+
+	status, resp := fn()
+
+	base.accessLogCh <- accessLogRecord{
+		Time:       start,
+		RequestID:  id,
+		SocketID:   (*so).Id(),
+		Path:       "socket.io/" + event,
+		DurationMs: time.Since(start).Milliseconds(),
+		Status:     status,
+	}
+
+	return status, injectRequestID(resp, id)
+}
+
+// injectRequestID adds request_id to a JSON object ack payload. Payloads
+// that are not a JSON object are returned unchanged.
+func injectRequestID(payload, id string) string {
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &m); err != nil || m == nil {
+		return payload
+	}
+	m["request_id"] = id
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return payload
+	}
+	return string(b)
+}
+
+// accessLogHandle drains ch and writes one line per request: JSON when
+// jsonFormat is set (log_format = json), otherwise plain text. This
+// replaces the old verbose-only println stream so requests can be shipped
+// to Loki/ELK and correlated with the SiriDB server logs.
+func accessLogHandle(ch chan accessLogRecord, jsonFormat bool) {
+	for rec := range ch {
+		if jsonFormat {
+			if b, err := json.Marshal(rec); err == nil {
+				fmt.Println(string(b))
+			}
+			continue
+		}
+
+		fmt.Printf(
+			"%s id=%s addr=%s socket=%s user=%s path=%s query=%s duration=%dms status=%d\n",
+			rec.Time.Format(time.RFC3339),
+			rec.RequestID,
+			rec.RemoteAddr,
+			rec.SocketID,
+			rec.User,
+			rec.Path,
+			rec.QueryHash,
+			rec.DurationMs,
+			rec.Status,
+		)
+	}
+}
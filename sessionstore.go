@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const pruneInterval = time.Hour
+
+// sessionCookieName is the cookie used to carry a session token for any
+// login method (password, OIDC, ...) that ends up in base.tokens.
+const sessionCookieName = "siridbsessionid"
+
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// issueSessionToken generates a new session token for user, saves it in
+// base.tokens and returns it. rememberMe selects rememberMaxAge over
+// cookieMaxAge for the token's expiry. Shared by the HTTP cookie flow
+// (createSession) and the socket.io auth handlers, which have no cookie jar
+// of their own and hand the token back to the client directly.
+func issueSessionToken(user string, rememberMe bool) (sessionToken, error) {
+	token, err := generateToken()
+	if err != nil {
+		return sessionToken{}, err
+	}
+
+	maxAge := base.cookieMaxAge
+	if rememberMe {
+		maxAge = base.rememberMaxAge
+	}
+
+	t := sessionToken{
+		Token:   token,
+		User:    user,
+		Expires: time.Now().Add(time.Duration(maxAge) * time.Second),
+	}
+	if err := base.tokens.save(t); err != nil {
+		return sessionToken{}, err
+	}
+
+	return t, nil
+}
+
+// createSession issues a new session token for user and sets it as the
+// session cookie on w. rememberMe selects rememberMaxAge over cookieMaxAge
+// for the cookie's expiry.
+func createSession(w http.ResponseWriter, user string, rememberMe bool) (string, error) {
+	t, err := issueSessionToken(user, rememberMe)
+	if err != nil {
+		return "", err
+	}
+
+	maxAge := base.cookieMaxAge
+	if rememberMe {
+		maxAge = base.rememberMaxAge
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    t.Token,
+		MaxAge:   int(maxAge),
+		Path:     "/",
+		HttpOnly: true,
+	})
+
+	return t.Token, nil
+}
+
+// connForSessionUser resolves the user stored in a session token to the
+// SiriDB connection it should execute under.
+func connForSessionUser(user string) (Conn, bool) {
+	for _, conn := range base.connections {
+		if conn.user == user {
+			return conn, true
+		}
+	}
+	return Conn{}, false
+}
+
+// sessionFromRequest validates the session cookie (if any) against
+// base.tokens and resolves it to the SiriDB connection for its user.
+func sessionFromRequest(r *http.Request) (Conn, bool) {
+	if base.tokens == nil {
+		return Conn{}, false
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return Conn{}, false
+	}
+
+	t, ok := base.tokens.get(cookie.Value)
+	if !ok {
+		return Conn{}, false
+	}
+
+	return connForSessionUser(t.User)
+}
+
+// wrapSessionAuth requires a valid session cookie. It always guards
+// /auth/fetch, and is the fallback for /query, /insert and /db-info when
+// neither mTLS nor Basic Auth is configured. With require_authentication
+// disabled it lets the request through unauthenticated; wrapAuth's mTLS and
+// Basic Auth branches do not.
+func wrapSessionAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !base.reqAuth {
+			next(w, r)
+			return
+		}
+
+		conn, ok := sessionFromRequest(r)
+		if !ok {
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, attachConn(r, conn))
+	}
+}
+
+// sessionToken is a single "remember me" or regular login session.
+type sessionToken struct {
+	Token   string    `json:"token"`
+	User    string    `json:"user"`
+	Expires time.Time `json:"expires"`
+}
+
+func (t sessionToken) expired() bool {
+	return time.Now().After(t.Expires)
+}
+
+// tokenStore is implemented by each session backend configured with
+// [Session] backend. A store must be safe for concurrent use.
+type tokenStore interface {
+	save(t sessionToken) error
+	get(token string) (sessionToken, bool)
+	delete(token string) error
+	prune() error
+}
+
+// newTokenStore builds the token store configured with [Session] backend.
+func newTokenStore(backend, path string) (tokenStore, error) {
+	switch backend {
+	case "file":
+		if path == "" {
+			return nil, fmt.Errorf("[Session] path is required when backend is \"file\"")
+		}
+		return newFileTokenStore(path)
+	case "sqlite":
+		if path == "" {
+			return nil, fmt.Errorf("[Session] path is required when backend is \"sqlite\"")
+		}
+		return newSQLiteTokenStore(path)
+	case "memory", "":
+		return newMemoryTokenStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown [Session] backend: %s", backend)
+	}
+}
+
+// pruneTokens removes expired tokens from store at a fixed interval. It is
+// meant to run for the lifetime of the process, started with `go`.
+func pruneTokens(store tokenStore, logCh chan string) {
+	for {
+		time.Sleep(pruneInterval)
+		if err := store.prune(); err != nil {
+			logCh <- fmt.Sprintf("failed to prune session tokens: %s", err)
+		}
+	}
+}
+
+// memoryTokenStore keeps tokens in a map and loses them on restart. This is
+// the default backend and matches the behaviour of the previous beego
+// in-memory session manager.
+type memoryTokenStore struct {
+	mux    sync.Mutex
+	tokens map[string]sessionToken
+}
+
+func newMemoryTokenStore() *memoryTokenStore {
+	return &memoryTokenStore{tokens: make(map[string]sessionToken)}
+}
+
+func (s *memoryTokenStore) save(t sessionToken) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.tokens[t.Token] = t
+	return nil
+}
+
+func (s *memoryTokenStore) get(token string) (sessionToken, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	t, ok := s.tokens[token]
+	if !ok || t.expired() {
+		return sessionToken{}, false
+	}
+	return t, true
+}
+
+func (s *memoryTokenStore) delete(token string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.tokens, token)
+	return nil
+}
+
+func (s *memoryTokenStore) prune() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for token, t := range s.tokens {
+		if t.expired() {
+			delete(s.tokens, token)
+		}
+	}
+	return nil
+}
+
+// fileTokenStore persists tokens as a JSON file so sessions survive a
+// siridb-http restart without requiring a database.
+type fileTokenStore struct {
+	mux  sync.Mutex
+	path string
+}
+
+func newFileTokenStore(path string) (*fileTokenStore, error) {
+	s := &fileTokenStore{path: path}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := s.writeAll(map[string]sessionToken{}); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *fileTokenStore) readAll() (map[string]sessionToken, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tokens := make(map[string]sessionToken)
+	if err := json.NewDecoder(f).Decode(&tokens); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (s *fileTokenStore) writeAll(tokens map[string]sessionToken) error {
+	f, err := os.Create(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(tokens)
+}
+
+func (s *fileTokenStore) save(t sessionToken) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	tokens, err := s.readAll()
+	if err != nil {
+		return err
+	}
+	tokens[t.Token] = t
+	return s.writeAll(tokens)
+}
+
+func (s *fileTokenStore) get(token string) (sessionToken, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	tokens, err := s.readAll()
+	if err != nil {
+		return sessionToken{}, false
+	}
+	t, ok := tokens[token]
+	if !ok || t.expired() {
+		return sessionToken{}, false
+	}
+	return t, true
+}
+
+func (s *fileTokenStore) delete(token string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	tokens, err := s.readAll()
+	if err != nil {
+		return err
+	}
+	delete(tokens, token)
+	return s.writeAll(tokens)
+}
+
+func (s *fileTokenStore) prune() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	tokens, err := s.readAll()
+	if err != nil {
+		return err
+	}
+	for token, t := range tokens {
+		if t.expired() {
+			delete(tokens, token)
+		}
+	}
+	return s.writeAll(tokens)
+}
+
+// sqliteTokenStore persists tokens in a local SQLite database, the
+// recommended backend for anything more than a handful of users.
+type sqliteTokenStore struct {
+	db *sql.DB
+}
+
+func newSQLiteTokenStore(path string) (*sqliteTokenStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS sessions (
+		token TEXT PRIMARY KEY,
+		user TEXT NOT NULL,
+		expires INTEGER NOT NULL
+	)`); err != nil {
+		return nil, err
+	}
+
+	return &sqliteTokenStore{db: db}, nil
+}
+
+func (s *sqliteTokenStore) save(t sessionToken) error {
+	_, err := s.db.Exec(
+		`INSERT OR REPLACE INTO sessions (token, user, expires) VALUES (?, ?, ?)`,
+		t.Token, t.User, t.Expires.Unix())
+	return err
+}
+
+func (s *sqliteTokenStore) get(token string) (sessionToken, bool) {
+	var t sessionToken
+	var expires int64
+	row := s.db.QueryRow(`SELECT token, user, expires FROM sessions WHERE token = ?`, token)
+	if err := row.Scan(&t.Token, &t.User, &expires); err != nil {
+		return sessionToken{}, false
+	}
+	t.Expires = time.Unix(expires, 0)
+	if t.expired() {
+		return sessionToken{}, false
+	}
+	return t, true
+}
+
+func (s *sqliteTokenStore) delete(token string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE token = ?`, token)
+	return err
+}
+
+func (s *sqliteTokenStore) prune() error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE expires < ?`, time.Now().Unix())
+	return err
+}
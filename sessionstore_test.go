@@ -0,0 +1,129 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSessionTokenExpired(t *testing.T) {
+	future := sessionToken{Expires: time.Now().Add(time.Hour)}
+	if future.expired() {
+		t.Error("token expiring an hour from now should not be expired")
+	}
+
+	past := sessionToken{Expires: time.Now().Add(-time.Hour)}
+	if !past.expired() {
+		t.Error("token that expired an hour ago should be expired")
+	}
+}
+
+func TestMemoryTokenStoreSaveGet(t *testing.T) {
+	s := newMemoryTokenStore()
+
+	t1 := sessionToken{Token: "abc", User: "iris", Expires: time.Now().Add(time.Hour)}
+	if err := s.save(t1); err != nil {
+		t.Fatalf("save: %s", err)
+	}
+
+	got, ok := s.get("abc")
+	if !ok {
+		t.Fatal("expected to find saved token")
+	}
+	if got.User != "iris" {
+		t.Errorf("got user %q, want %q", got.User, "iris")
+	}
+
+	if _, ok := s.get("does-not-exist"); ok {
+		t.Error("expected no token for an unknown key")
+	}
+}
+
+func TestMemoryTokenStoreGetExpired(t *testing.T) {
+	s := newMemoryTokenStore()
+
+	expired := sessionToken{Token: "abc", User: "iris", Expires: time.Now().Add(-time.Minute)}
+	if err := s.save(expired); err != nil {
+		t.Fatalf("save: %s", err)
+	}
+
+	if _, ok := s.get("abc"); ok {
+		t.Error("expired token should not be returned by get")
+	}
+}
+
+func TestMemoryTokenStorePrune(t *testing.T) {
+	s := newMemoryTokenStore()
+
+	s.save(sessionToken{Token: "expired", Expires: time.Now().Add(-time.Minute)})
+	s.save(sessionToken{Token: "live", Expires: time.Now().Add(time.Hour)})
+
+	if err := s.prune(); err != nil {
+		t.Fatalf("prune: %s", err)
+	}
+
+	if _, ok := s.tokens["expired"]; ok {
+		t.Error("expired token should have been pruned")
+	}
+	if _, ok := s.tokens["live"]; !ok {
+		t.Error("live token should not have been pruned")
+	}
+}
+
+func TestMemoryTokenStoreDelete(t *testing.T) {
+	s := newMemoryTokenStore()
+	s.save(sessionToken{Token: "abc", Expires: time.Now().Add(time.Hour)})
+
+	if err := s.delete("abc"); err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	if _, ok := s.get("abc"); ok {
+		t.Error("deleted token should no longer be returned by get")
+	}
+}
+
+func TestFileTokenStoreSaveGetPruneDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+
+	s, err := newFileTokenStore(path)
+	if err != nil {
+		t.Fatalf("newFileTokenStore: %s", err)
+	}
+
+	live := sessionToken{Token: "live", User: "iris", Expires: time.Now().Add(time.Hour)}
+	expired := sessionToken{Token: "expired", Expires: time.Now().Add(-time.Minute)}
+	if err := s.save(live); err != nil {
+		t.Fatalf("save live: %s", err)
+	}
+	if err := s.save(expired); err != nil {
+		t.Fatalf("save expired: %s", err)
+	}
+
+	if got, ok := s.get("live"); !ok || got.User != "iris" {
+		t.Errorf("get(live) = %+v, %v; want user iris, ok true", got, ok)
+	}
+	if _, ok := s.get("expired"); ok {
+		t.Error("expired token should not be returned by get")
+	}
+
+	if err := s.prune(); err != nil {
+		t.Fatalf("prune: %s", err)
+	}
+	tokens, err := s.readAll()
+	if err != nil {
+		t.Fatalf("readAll: %s", err)
+	}
+	if _, ok := tokens["expired"]; ok {
+		t.Error("expired token should have been pruned from disk")
+	}
+	if _, ok := tokens["live"]; !ok {
+		t.Error("live token should not have been pruned from disk")
+	}
+
+	if err := s.delete("live"); err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	if _, ok := s.get("live"); ok {
+		t.Error("deleted token should no longer be returned by get")
+	}
+}
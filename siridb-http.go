@@ -1,7 +1,8 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,9 +11,11 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/astaxie/beego/session"
+	auth "github.com/abbot/go-http-auth"
+	oidc "github.com/coreos/go-oidc"
 	"github.com/googollee/go-socket.io"
 	siridb "github.com/transceptor-technology/go-siridb-connector"
+	"golang.org/x/oauth2"
 
 	"time"
 
@@ -33,24 +36,37 @@ type Conn struct {
 }
 
 type store struct {
-	connections   []Conn
-	dbname        string
-	timePrecision string
-	version       string
-	servers       []server
-	port          uint16
-	insertTimeout uint16
-	logCh         chan string
-	reqAuth       bool
-	multiUser     bool
-	enableWeb     bool
-	enableSio     bool
-	enableSSL     bool
-	ssessions     map[string]string
-	cookieMaxAge  uint64
-	crtFile       string
-	keyFile       string
-	gsessions     *session.Manager
+	connections              []Conn
+	dbname                   string
+	timePrecision            string
+	version                  string
+	servers                  []server
+	port                     uint16
+	insertTimeout            uint16
+	shutdownTimeout          uint16
+	logFormat                string
+	logCh                    chan string
+	accessLogCh              chan accessLogRecord
+	reqAuth                  bool
+	multiUser                bool
+	enableWeb                bool
+	enableSio                bool
+	enableSSL                bool
+	ssessions                map[string]string
+	cookieMaxAge             uint64
+	rememberMaxAge           uint64
+	crtFile                  string
+	keyFile                  string
+	tlsConfig                *tls.Config
+	clientAuthMode           tls.ClientAuthType
+	clientAuthPrincipalField string
+	tokens                   tokenStore
+	basicAuth                *auth.BasicAuth
+	oidcConfig               oauth2.Config
+	oidcVerifier             *oidc.IDTokenVerifier
+	oidcUsernameClaim        string
+	oidcUserMap              map[string]string
+	oidcEndSessionURL        string
 }
 
 type server struct {
@@ -67,6 +83,8 @@ var (
 
 var base = store{}
 
+var httpServer *http.Server
+
 func getHostAndPort(addr string) (server, error) {
 	parts := strings.Split(addr, ":")
 	// IPv4
@@ -128,8 +146,30 @@ func sigHandle(sigCh chan os.Signal) {
 	for {
 		<-sigCh
 		println("CTRL+C pressed...")
+		shutdown()
+	}
+}
+
+// shutdown stops accepting new HTTP and socket.io connections (they share
+// the same listener) and waits up to shutdown_timeout seconds for in-flight
+// /query and /insert handlers to finish before closing the SiriDB
+// connections and exiting.
+func shutdown() {
+	if httpServer == nil {
 		quit(nil)
+		return
 	}
+
+	fmt.Printf("shutting down, waiting up to %ds for in-flight requests to finish...\n", base.shutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(base.shutdownTimeout)*time.Second)
+	defer cancel()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		fmt.Printf("error during shutdown: %s\n", err)
+	}
+
+	quit(nil)
 }
 
 func quit(err error) {
@@ -144,6 +184,7 @@ func quit(err error) {
 			conn.client.Close()
 		}
 	}
+	closeBasicAuthConns()
 
 	os.Exit(rc)
 }
@@ -235,10 +276,46 @@ enable_web = True
 # this configuration file can be used to create a session connection to SiriDB.
 enable_multi_user = False
 cookie_max_age = 604800
+# Max-Age used for the session cookie when a login requests "stay_logged_in".
+remember_me_max_age = 2592000
 insert_timeout = 60
+# Seconds to wait for in-flight /query and /insert requests to finish when
+# shutting down before closing the SiriDB connections anyway.
+shutdown_timeout = 30
+# "json" emits one structured access log line per request (request id,
+# remote addr, user, path, duration, status), handy for shipping to
+# Loki/ELK. Anything else logs the same fields as plain text.
+log_format = text
 # In case a secret is set, the secret can be used to authenticate each request.
 # secret = my_super_secret
 
+[Session]
+# Where session tokens are kept. "memory" loses all sessions on restart,
+# "file" and "sqlite" persist them to disk so a restart does not force
+# every user to log in again.
+backend = memory
+# Required when backend is "file" or "sqlite".
+# path = sessions.db
+
+[Basic]
+# When set, /query, /insert and /db-info require HTTP Basic Auth
+# credentials checked against this htpasswd file instead of the session
+# cookie, so curl/Grafana/Prometheus can hit the API without the
+# /auth/login cookie dance.
+# htpasswd_file = /etc/siridb/users.htpasswd
+
+[OIDC]
+# When issuer_url is set, /auth/oidc/login and /auth/oidc/callback perform
+# the authorization-code flow against the issuer (e.g. Keycloak, Dex,
+# Hydra) and map username_claim to a SiriDB user via siridb_user_map, a
+# comma-separated list of <issuer username>=<siridb user> pairs.
+# issuer_url = https://login.example.com/realms/example
+# client_id = siridb-http
+# client_secret = my_super_secret
+# redirect_url = http://localhost:8080/auth/oidc/callback
+# username_claim = preferred_username
+# siridb_user_map = alice=iris,bob=iris
+
 [SSL]
 # Self-signed certificates can be created using:
 #
@@ -247,6 +324,19 @@ insert_timeout = 60
 #
 crt_file = my_certificate.crt
 key_file = my_certificate.key
+# min_tls_version = 1.2
+# Comma-separated cipher suite names, see the Go crypto/tls package.
+# ciphers = TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+# PEM file with the CA(s) used to verify client certificates.
+# client_ca_file = my_client_ca.crt
+# none|request|require|verify|require_and_verify, matching
+# crypto/tls.ClientAuthType. When require_and_verify is used, the peer
+# certificate's Common Name (or a SAN, see client_auth_principal_field)
+# is used as the authenticated SiriDB user for /query, /insert and
+# /db-info, bypassing the cookie/session flow entirely.
+# client_auth_mode = none
+# cn|dns|email
+# client_auth_principal_field = cn
 
 #
 # Welcome and thank you for using SiriDB!
@@ -322,6 +412,8 @@ key_file = my_certificate.key
 		quit(err)
 	}
 
+	base.rememberMaxAge = section.Key("remember_me_max_age").MustUint64(2592000)
+
 	if insertTimeoutIni, err := section.GetKey("insert_timeout"); err != nil {
 		quit(err)
 	} else if insertTimeout64, err := insertTimeoutIni.Uint64(); err != nil {
@@ -330,6 +422,13 @@ key_file = my_certificate.key
 		base.insertTimeout = uint16(insertTimeout64)
 	}
 
+	base.shutdownTimeout = uint16(section.Key("shutdown_timeout").MustUint64(30))
+
+	base.logFormat = section.Key("log_format").MustString("text")
+
+	base.accessLogCh = make(chan accessLogRecord, 100)
+	go accessLogHandle(base.accessLogCh, base.logFormat == "json")
+
 	if base.enableSSL {
 		section, err = cfg.GetSection("SSL")
 		if err != nil {
@@ -337,51 +436,85 @@ key_file = my_certificate.key
 		}
 		base.crtFile = readString(section, "crt_file")
 		base.keyFile = readString(section, "key_file")
+
+		if base.tlsConfig, err = buildTLSConfig(section); err != nil {
+			quit(err)
+		}
 	}
 
-	http.HandleFunc("*", handlerNotFound)
+	if section, err = cfg.GetSection("Basic"); err == nil {
+		if htpasswdFile := readString(section, "htpasswd_file"); htpasswdFile != "" {
+			base.basicAuth = newBasicAuthenticator(htpasswdFile)
+		}
+	}
 
-	if base.enableWeb {
-		http.HandleFunc("/", handlerMain)
-		http.HandleFunc("/js/bundle", handlerJsBundle)
-		http.HandleFunc("/js/jsleri", handlerLeriMinJS)
-		http.HandleFunc("/js/grammar", handlerGrammarJS)
-		http.HandleFunc("/css/bootstrap", handlerBootstrapCSS)
-		http.HandleFunc("/css/layout", handlerLayout)
-		http.HandleFunc("/favicon.ico", handlerFaviconIco)
-		http.HandleFunc("/img/siridb-large.png", handlerSiriDBLargePNG)
-		http.HandleFunc("/img/siridb-small.png", handlerSiriDBSmallPNG)
-		http.HandleFunc("/img/loader.gif", handlerLoaderGIF)
-		http.HandleFunc("/css/font-awesome.min.css", handlerFontAwesomeMinCSS)
-		http.HandleFunc("/fonts/FontAwesome.otf", handlerFontsFaOTF)
-		http.HandleFunc("/fonts/fontawesome-webfont.eot", handlerFontsFaEOT)
-		http.HandleFunc("/fonts/fontawesome-webfont.svg", handlerFontsFaSVG)
-		http.HandleFunc("/fonts/fontawesome-webfont.ttf", handlerFontsFaTTF)
-		http.HandleFunc("/fonts/fontawesome-webfont.woff", handlerFontsFaWOFF)
-		http.HandleFunc("/fonts/fontawesome-webfont.woff2", handlerFontsFaWOFF2)
-	}
-
-	http.HandleFunc("/db-info", handlerDbInfo)
-	http.HandleFunc("/auth/fetch", handlerAuthFetch)
-	http.HandleFunc("/query", handlerQuery)
-	http.HandleFunc("/insert", handlerInsert)
+	oidcEnabled := false
+	if section, err = cfg.GetSection("OIDC"); err == nil {
+		if readString(section, "issuer_url") != "" {
+			if err = setupOIDC(section); err != nil {
+				quit(err)
+			}
+			oidcEnabled = true
+		}
+	}
 
-	if base.reqAuth {
-		cf := new(session.ManagerConfig)
-		cf.EnableSetCookie = true
-		s := fmt.Sprintf(`{"cookieName":"siridbadminsessionid","gclifetime":%d}`, base.cookieMaxAge)
+	handle("*", handlerNotFound)
 
-		if err = json.Unmarshal([]byte(s), cf); err != nil {
+	if base.enableWeb {
+		handle("/", handlerMain)
+		handle("/js/bundle", handlerJsBundle)
+		handle("/js/jsleri", handlerLeriMinJS)
+		handle("/js/grammar", handlerGrammarJS)
+		handle("/css/bootstrap", handlerBootstrapCSS)
+		handle("/css/layout", handlerLayout)
+		handle("/favicon.ico", handlerFaviconIco)
+		handle("/img/siridb-large.png", handlerSiriDBLargePNG)
+		handle("/img/siridb-small.png", handlerSiriDBSmallPNG)
+		handle("/img/loader.gif", handlerLoaderGIF)
+		handle("/css/font-awesome.min.css", handlerFontAwesomeMinCSS)
+		handle("/fonts/FontAwesome.otf", handlerFontsFaOTF)
+		handle("/fonts/fontawesome-webfont.eot", handlerFontsFaEOT)
+		handle("/fonts/fontawesome-webfont.svg", handlerFontsFaSVG)
+		handle("/fonts/fontawesome-webfont.ttf", handlerFontsFaTTF)
+		handle("/fonts/fontawesome-webfont.woff", handlerFontsFaWOFF)
+		handle("/fonts/fontawesome-webfont.woff2", handlerFontsFaWOFF2)
+	}
+
+	handle("/db-info", wrapAuth(handlerDbInfo))
+	handle("/auth/fetch", wrapSessionAuth(handlerAuthFetch))
+	handle("/query", wrapAuth(handlerQuery))
+	handle("/insert", wrapAuth(handlerInsert))
+
+	if base.reqAuth || oidcEnabled {
+		sessionBackend := "memory"
+		sessionPath := ""
+		if section, err = cfg.GetSection("Session"); err == nil {
+			sessionBackend = section.Key("backend").MustString("memory")
+			if sessionBackend == "file" || sessionBackend == "sqlite" {
+				sessionPath = readString(section, "path")
+			}
+		}
+
+		if base.tokens, err = newTokenStore(sessionBackend, sessionPath); err != nil {
 			quit(err)
 		}
 
-		if base.gsessions, err = session.NewManager("memory", cf); err != nil {
+		if err = base.tokens.prune(); err != nil {
 			quit(err)
 		}
 
-		go base.gsessions.GC()
-		http.HandleFunc("/auth/login", handlerAuthLogin)
-		http.HandleFunc("/auth/logout", handlerAuthLogout)
+		go pruneTokens(base.tokens, base.logCh)
+	}
+
+	if base.reqAuth {
+		handle("/auth/login", handlerAuthLogin)
+		handle("/auth/logout", handlerAuthLogout)
+	}
+
+	if oidcEnabled {
+		handle("/auth/oidc/login", handlerOIDCLogin)
+		handle("/auth/oidc/callback", handlerOIDCCallback)
+		handle("/auth/oidc/logout", handlerOIDCLogout)
 	}
 
 	conn.client.Connect()
@@ -395,22 +528,34 @@ key_file = my_certificate.key
 
 		server.On("connection", func(so socketio.Socket) {
 			so.On("db-info", func(req string) (int, string) {
-				return onDbInfo(&so)
+				return logSocketEvent(&so, "db-info", func() (int, string) {
+					return onDbInfo(&so)
+				})
 			})
 			so.On("auth fetch", func(req string) (int, string) {
-				return onAuthFetch(&so)
+				return logSocketEvent(&so, "auth fetch", func() (int, string) {
+					return onAuthFetch(&so, req)
+				})
 			})
 			so.On("auth login", func(req string) (int, string) {
-				return onAuthLogin(&so, req)
+				return logSocketEvent(&so, "auth login", func() (int, string) {
+					return onAuthLogin(&so, req)
+				})
 			})
 			so.On("auth logout", func(req string) (int, string) {
-				return onAuthLogout(&so)
+				return logSocketEvent(&so, "auth logout", func() (int, string) {
+					return onAuthLogout(&so)
+				})
 			})
 			so.On("query", func(req string) (int, string) {
-				return onQuery(&so, req)
+				return logSocketEvent(&so, "query", func() (int, string) {
+					return onQuery(&so, req)
+				})
 			})
 			so.On("insert", func(req string) (int, string) {
-				return onInsert(&so, req)
+				return logSocketEvent(&so, "insert", func() (int, string) {
+					return onInsert(&so, req)
+				})
 			})
 			so.On("disconnection", func() {
 				delete(base.ssessions, so.Id())
@@ -424,20 +569,24 @@ key_file = my_certificate.key
 		http.Handle("/socket.io/", server)
 	}
 
+	httpServer = &http.Server{Addr: fmt.Sprintf(":%d", base.port), TLSConfig: base.tlsConfig}
+
 	msg := "Serving SiriDB API on http%s://0.0.0.0:%d\nPress CTRL+C to quit\n"
 	if base.enableSSL {
 		fmt.Printf(msg, "s", base.port)
-		if err = http.ListenAndServeTLS(
-			fmt.Sprintf(":%d", base.port),
-			base.crtFile,
-			base.keyFile,
-			nil); err != nil {
+		if err = httpServer.ListenAndServeTLS(base.crtFile, base.keyFile); err != nil && err != http.ErrServerClosed {
 			fmt.Printf("error: %s\n", err)
 		}
 	} else {
 		fmt.Printf(msg, "", base.port)
-		if err = http.ListenAndServe(fmt.Sprintf(":%d", base.port), nil); err != nil {
+		if err = httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			fmt.Printf("error: %s\n", err)
 		}
 	}
-}
\ No newline at end of file
+
+	// ListenAndServe returns as soon as Shutdown starts; block here so
+	// shutdown() can drain in-flight requests and exit through quit().
+	if err == http.ErrServerClosed {
+		select {}
+	}
+}
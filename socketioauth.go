@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+// sessionFetchRequest is the payload a socket.io client sends on "auth
+// fetch" to re-authenticate an existing session token, e.g. one it stored
+// in localStorage across a page reload or a siridb-http restart.
+type sessionFetchRequest struct {
+	Token string `json:"token"`
+}
+
+// authResponse acks a socket.io auth event with the session token the
+// client should hold onto and resend on "auth fetch".
+type authResponse struct {
+	Token string `json:"token"`
+}
+
+func jsonResponse(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return `{"error":"internal error"}`
+	}
+	return string(b)
+}
+
+func errorResponse(msg string) string {
+	return jsonResponse(struct {
+		Error string `json:"error"`
+	}{Error: msg})
+}
+
+// onAuthLogin authenticates a user/password pair the same way
+// handlerAuthLogin does for the HTTP API, and issues a session token so the
+// login survives both a page reload and a siridb-http restart -- unlike the
+// HTTP flow there is no response cookie jar, so the token is handed back in
+// the ack payload for the client to resend on "auth fetch".
+func onAuthLogin(so *socketio.Socket, req string) (int, string) {
+	var r authLoginRequest
+	if err := json.Unmarshal([]byte(req), &r); err != nil {
+		return http.StatusBadRequest, errorResponse("invalid request body")
+	}
+
+	conn, err := authenticateUser(r.User, r.Password)
+	if err != nil {
+		return http.StatusUnauthorized, errorResponse(err.Error())
+	}
+
+	t, err := issueSessionToken(conn.user, r.StayLoggedIn)
+	if err != nil {
+		return http.StatusInternalServerError, errorResponse(err.Error())
+	}
+
+	base.ssessions[(*so).Id()] = t.Token
+
+	return http.StatusOK, jsonResponse(authResponse{Token: t.Token})
+}
+
+// onAuthFetch validates a session token (from a prior onAuthLogin, a
+// password login via /auth/login, or an OIDC login) against base.tokens and,
+// on success, associates it with this socket so onQuery/onInsert/onDbInfo
+// can resolve the connection to use without asking the client to log in
+// again.
+func onAuthFetch(so *socketio.Socket, req string) (int, string) {
+	var r sessionFetchRequest
+	if err := json.Unmarshal([]byte(req), &r); err != nil || r.Token == "" {
+		return http.StatusUnauthorized, errorResponse("authentication required")
+	}
+
+	if _, ok := base.tokens.get(r.Token); !ok {
+		return http.StatusUnauthorized, errorResponse("invalid or expired session")
+	}
+
+	base.ssessions[(*so).Id()] = r.Token
+
+	return http.StatusOK, jsonResponse(authResponse{Token: r.Token})
+}
+
+// onAuthLogout deletes the session token associated with this socket from
+// base.tokens, the socket.io equivalent of handlerAuthLogout.
+func onAuthLogout(so *socketio.Socket) (int, string) {
+	if token, ok := base.ssessions[(*so).Id()]; ok {
+		base.tokens.delete(token)
+		delete(base.ssessions, (*so).Id())
+	}
+
+	return http.StatusOK, "{}"
+}
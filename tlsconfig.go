@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	ini "gopkg.in/ini.v1"
+)
+
+// buildTLSConfig reads the [SSL] section beyond crt_file/key_file and
+// builds the *tls.Config passed to http.Server.TLSConfig, so
+// min_tls_version, ciphers, client_ca_file and client_auth_mode are
+// actually enforced instead of relying on ListenAndServeTLS's defaults.
+func buildTLSConfig(section *ini.Section) (*tls.Config, error) {
+	cfg := &tls.Config{}
+
+	if v := section.Key("min_tls_version").String(); v != "" {
+		version, err := parseTLSVersion(v)
+		if err != nil {
+			return nil, err
+		}
+		cfg.MinVersion = version
+	}
+
+	if v := section.Key("ciphers").String(); v != "" {
+		suites, err := parseCipherSuites(v)
+		if err != nil {
+			return nil, err
+		}
+		cfg.CipherSuites = suites
+	}
+
+	base.clientAuthMode = tls.NoClientCert
+	if v := section.Key("client_auth_mode").String(); v != "" {
+		mode, err := parseClientAuthMode(v)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientAuth = mode
+		base.clientAuthMode = mode
+	}
+
+	if v := section.Key("client_ca_file").String(); v != "" {
+		pem, err := os.ReadFile(v)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client_ca_file: %s", v)
+		}
+		cfg.ClientCAs = pool
+	}
+
+	base.clientAuthPrincipalField = section.Key("client_auth_principal_field").String()
+	if base.clientAuthPrincipalField == "" {
+		base.clientAuthPrincipalField = "cn"
+	}
+
+	return cfg, nil
+}
+
+func parseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported min_tls_version: %s", v)
+	}
+}
+
+func parseCipherSuites(v string) ([]uint16, error) {
+	all := append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+	byName := make(map[string]uint16, len(all))
+	for _, c := range all {
+		byName[c.Name] = c.ID
+	}
+
+	var suites []uint16
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite: %s", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
+func parseClientAuthMode(v string) (tls.ClientAuthType, error) {
+	switch v {
+	case "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require":
+		return tls.RequireAnyClientCert, nil
+	case "verify":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require_and_verify":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("unknown client_auth_mode: %s", v)
+	}
+}
+
+// clientCertPrincipal returns the authenticated principal for a request
+// carrying a verified client certificate, when client_auth_mode is
+// require_and_verify.
+func clientCertPrincipal(r *http.Request) (string, bool) {
+	if base.clientAuthMode != tls.RequireAndVerifyClientCert {
+		return "", false
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", false
+	}
+
+	cert := r.TLS.PeerCertificates[0]
+	switch base.clientAuthPrincipalField {
+	case "dns":
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0], true
+		}
+	case "email":
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0], true
+		}
+	}
+	return cert.Subject.CommonName, true
+}
+
+// connForClientCertPrincipal resolves a client certificate's principal to
+// the (statically configured) SiriDB connection it authenticates as. There
+// is no client-supplied password in an mTLS-only deployment, so this only
+// ever matches one of the connections set up from [Database] at startup.
+func connForClientCertPrincipal(principal string) (Conn, bool) {
+	for _, conn := range base.connections {
+		if conn.user == principal {
+			return conn, true
+		}
+	}
+	return Conn{}, false
+}
+
+// wrapAuth authenticates /query, /insert and /db-info via, in order, a
+// verified mTLS client certificate, HTTP Basic Auth, or (when neither is
+// configured) the session cookie.
+func wrapAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if principal, ok := clientCertPrincipal(r); ok {
+			conn, ok := connForClientCertPrincipal(principal)
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown SiriDB user for client certificate: %s", principal), http.StatusUnauthorized)
+				return
+			}
+			next(w, attachConn(r, conn))
+			return
+		}
+
+		wrapOptionalBasicAuth(next)(w, r)
+	}
+}
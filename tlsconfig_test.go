@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    uint16
+		wantErr bool
+	}{
+		{"1.2", tls.VersionTLS12, false},
+		{"1.3", tls.VersionTLS13, false},
+		{"1.1", 0, true},
+		{"", 0, true},
+	}
+
+	for _, c := range cases {
+		got, err := parseTLSVersion(c.in)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseTLSVersion(%q): expected error, got none", c.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTLSVersion(%q): unexpected error: %s", c.in, err)
+		}
+		if got != c.want {
+			t.Errorf("parseTLSVersion(%q) = %d, want %d", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	suites, err := parseCipherSuites("TLS_AES_128_GCM_SHA256")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(suites) != 1 || suites[0] != tls.TLS_AES_128_GCM_SHA256 {
+		t.Errorf("got %v, want [TLS_AES_128_GCM_SHA256]", suites)
+	}
+
+	if _, err := parseCipherSuites("NOT_A_REAL_CIPHER"); err == nil {
+		t.Error("expected error for an unknown cipher suite name")
+	}
+}
+
+func TestParseClientAuthMode(t *testing.T) {
+	cases := map[string]tls.ClientAuthType{
+		"none":               tls.NoClientCert,
+		"request":            tls.RequestClientCert,
+		"require":            tls.RequireAnyClientCert,
+		"verify":             tls.VerifyClientCertIfGiven,
+		"require_and_verify": tls.RequireAndVerifyClientCert,
+	}
+
+	for in, want := range cases {
+		got, err := parseClientAuthMode(in)
+		if err != nil {
+			t.Errorf("parseClientAuthMode(%q): unexpected error: %s", in, err)
+		}
+		if got != want {
+			t.Errorf("parseClientAuthMode(%q) = %v, want %v", in, got, want)
+		}
+	}
+
+	if _, err := parseClientAuthMode("bogus"); err == nil {
+		t.Error("expected error for an unknown client_auth_mode")
+	}
+}